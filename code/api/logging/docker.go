@@ -0,0 +1,30 @@
+package logging
+
+import (
+	"github.com/lwch/api"
+)
+
+type dockerConfig struct {
+	Host     string `json:"host"`     // docker host group, used to pick a collector reachable to it
+	Endpoint string `json:"endpoint"` // unix:///var/run/docker.sock or tcp://host:port
+	TLSCert  string `json:"tls_cert,omitempty"`
+	TLSKey   string `json:"tls_key,omitempty"`
+	TLSCA    string `json:"tls_ca,omitempty"`
+	Name     string `json:"name"`  // container name filter, supports partial match
+	Label    string `json:"label"` // container label selector, e.g. "app=foo"
+	Since    string `json:"since,omitempty"`
+	Tail     string `json:"tail,omitempty"`
+}
+
+func (cfg *dockerConfig) build(ctx *api.Context) error {
+	cfg.Host = ctx.XStr("host")
+	cfg.Endpoint = ctx.XStr("endpoint")
+	cfg.TLSCert = ctx.OStr("tls_cert", "")
+	cfg.TLSKey = ctx.OStr("tls_key", "")
+	cfg.TLSCA = ctx.OStr("tls_ca", "")
+	cfg.Name = ctx.OStr("name", "")
+	cfg.Label = ctx.OStr("label", "")
+	cfg.Since = ctx.OStr("since", "")
+	cfg.Tail = ctx.OStr("tail", "all")
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"regexp"
 	lapi "server/code/api"
 	"server/code/client"
+	"strconv"
 	"time"
 
 	"github.com/jkstack/anet"
@@ -18,12 +19,14 @@ import (
 )
 
 type configArgs struct {
-	Exclude  string      `json:"exclude"`
-	Batch    int         `json:"batch"`
-	Buffer   int         `json:"buffer"`
-	Interval int         `json:"interval"`
-	K8s      *k8sConfig  `json:"k8s,omitempty"`
-	File     *fileConfig `json:"file,omitempty"`
+	Exclude   string           `json:"exclude"`
+	Batch     int              `json:"batch"`
+	Buffer    int              `json:"buffer"`
+	Interval  int              `json:"interval"`
+	Multiline *multilineConfig `json:"multiline,omitempty"`
+	K8s       *k8sConfig       `json:"k8s,omitempty"`
+	File      *fileConfig      `json:"file,omitempty"`
+	Docker    *dockerConfig    `json:"docker,omitempty"`
 }
 
 type context struct {
@@ -52,12 +55,32 @@ func (h *Handler) config(clients *client.Clients, ctx *api.Context) {
 		}
 	}
 
+	if pattern := ctx.OStr("multiline_start_pattern", ""); len(pattern) > 0 {
+		_, err = regexp.Compile(pattern)
+		if err != nil {
+			lapi.BadParamErr(fmt.Sprintf("multiline_start_pattern: %v", err))
+			return
+		}
+		mode := ctx.OStr("multiline_mode", multilineContinuePast)
+		if mode != multilineContinuePast && mode != multilineContinueThrough {
+			lapi.BadParamErr("multiline_mode")
+			return
+		}
+		rt.Args.Multiline = &multilineConfig{
+			StartPattern: pattern,
+			MaxLines:     ctx.OInt("multiline_max_lines", 500),
+			MaxInterval:  ctx.OInt("multiline_max_interval", 5000),
+			Mode:         mode,
+		}
+	}
+
 	switch t {
 	case "k8s":
 		rt.Args.K8s = new(k8sConfig)
 		err = rt.Args.K8s.build(ctx)
 	case "docker":
-		err = errors.New("unsupported")
+		rt.Args.Docker = new(dockerConfig)
+		err = rt.Args.Docker.build(ctx)
 	case "logtail":
 		rt.Args.File = new(fileConfig)
 		err = rt.Args.File.build(ctx)
@@ -67,12 +90,34 @@ func (h *Handler) config(clients *client.Clients, ctx *api.Context) {
 	}
 	runtime.Assert(err)
 
-	rt.CID, err = rt.Args.send(clients, rt.ID, h.cfg.LoggingReport)
-	if err == errNoCollector {
+	rememberSpoolEnv(h, clients)
+
+	rt.CID, err = rt.Args.send(clients, rt.ID, h.cfg.LoggingReport, h.cfg.LegacyCollectorAssignment)
+	if err != nil {
+		// Spool on any send failure, not just errNoCollector — a collector
+		// can be picked fine and sendTo still fail mid-stream (connection
+		// drop, client gone away between pick and send), and that case
+		// deserves the same retry treatment as never finding a collector
+		// at all, rather than panicking the request handler.
+		recordSendError(rt.ID, "", &rt.Args)
+		if msg := rt.Args.toMsg(rt.ID, h.cfg.LoggingReport); msg != nil {
+			s := getSpool(h.cfg.DataDir, rt.ID)
+			runtime.Assert(s.write(msg))
+			updateBacklogMetric(rt.ID, s)
+		}
+		// Keep the project around with an empty CID so the spool retry
+		// loop can find it again and assign a collector once one
+		// matching this selector reconnects, instead of the spooled
+		// data being orphaned forever.
+		dir := filepath.Join(h.cfg.DataDir, "logging", fmt.Sprintf("%d.json", rt.ID))
+		runtime.Assert(saveConfig(dir, rt))
+		h.Lock()
+		h.data[rt.ID] = &rt
+		h.Unlock()
+		startSpoolRetry()
 		ctx.ERR(1, err.Error())
 		return
 	}
-	runtime.Assert(err)
 
 	dir := filepath.Join(h.cfg.DataDir, "logging", fmt.Sprintf("%d.json", rt.ID))
 	err = saveConfig(dir, rt)
@@ -100,6 +145,7 @@ func (ctx *context) reSend(cli *client.Client, report string) {
 	if err != nil {
 		logging.Error("send logging config of project %d to client [%s]: %v",
 			ctx.ID, cli.ID())
+		ctx.spoolFailure(cli, report)
 		return
 	}
 	if ctx.Started {
@@ -107,6 +153,7 @@ func (ctx *context) reSend(cli *client.Client, report string) {
 		if err != nil {
 			logging.Error("send logging start of project %d: %v",
 				ctx.ID, cli.ID())
+			ctx.spoolFailure(cli, report)
 			return
 		}
 		defer cli.ChanClose(taskID)
@@ -116,6 +163,7 @@ func (ctx *context) reSend(cli *client.Client, report string) {
 		case <-time.After(api.RequestTimeout):
 			logging.Error("wait logging start status of project %d: %v",
 				ctx.ID, cli.ID())
+			ctx.spoolFailure(cli, report)
 			return
 		}
 
@@ -123,65 +171,127 @@ func (ctx *context) reSend(cli *client.Client, report string) {
 		case msg.Type == anet.TypeError:
 			logging.Error("get logging start status of project %d: %v",
 				ctx.ID, cli.ID())
+			ctx.spoolFailure(cli, report)
 			return
 		case msg.Type != anet.TypeLoggingStatusRep:
 			logging.Error("get logging start status of project %d: %v",
 				ctx.ID, cli.ID())
+			ctx.spoolFailure(cli, report)
 			return
 		}
 
 		if !msg.LoggingStatusRep.OK {
 			logging.Error("get logging start status of project %d: %v",
 				ctx.ID, cli.ID())
+			ctx.spoolFailure(cli, report)
 			return
 		}
 	}
 }
 
+// spoolFailure persists the config that cli failed to (fully) apply so the
+// spool retry loop can redeliver it once a matching collector is reachable
+// again. This covers both a failed sendTo and a failed start handshake.
+func (ctx *context) spoolFailure(cli *client.Client, report string) {
+	recordSendError(ctx.ID, cli.ID(), &ctx.Args)
+	msg := ctx.Args.toMsg(ctx.ID, report)
+	if msg == nil {
+		return
+	}
+	s := getSpool(spoolDataDir(), ctx.ID)
+	runtime.Assert(s.write(msg))
+	updateBacklogMetric(ctx.ID, s)
+	startSpoolRetry()
+}
+
 func (args *configArgs) sendTo(cli *client.Client, pid int64, report string) error {
+	mlPattern, mlMaxLines, mlMaxInterval, mlMode := args.Multiline.params()
 	switch {
 	case args.K8s != nil:
 		_, err := cli.SendLoggingConfigK8s(pid, args.Exclude,
 			args.Batch, args.Buffer, args.Interval, report,
-			args.K8s.Namespace, args.K8s.Names, args.K8s.Dir, args.K8s.Api, args.K8s.Token)
+			args.K8s.Namespace, args.K8s.Names, args.K8s.Dir, args.K8s.Api, args.K8s.Token,
+			mlPattern, mlMaxLines, mlMaxInterval, mlMode)
 		return err
 	case args.File != nil:
 		_, err := cli.SendLoggingConfigFile(pid, args.Exclude,
 			args.Batch, args.Buffer, args.Interval, report,
-			args.File.Dir)
+			args.File.Dir,
+			mlPattern, mlMaxLines, mlMaxInterval, mlMode)
+		return err
+	case args.Docker != nil:
+		_, err := cli.SendLoggingConfigDocker(pid, args.Exclude,
+			args.Batch, args.Buffer, args.Interval, report,
+			args.Docker.Endpoint, args.Docker.TLSCert, args.Docker.TLSKey, args.Docker.TLSCA,
+			args.Docker.Name, args.Docker.Label, args.Docker.Since, args.Docker.Tail,
+			mlPattern, mlMaxLines, mlMaxInterval, mlMode)
 		return err
 	default:
 		return errors.New("unsupported")
 	}
 }
 
-func (args *configArgs) send(clients *client.Clients, pid int64, report string) (string, error) {
-	var cli *client.Client
+func (args *configArgs) send(clients *client.Clients, pid int64, report string, legacyAssignment bool) (string, error) {
 	switch {
 	case args.K8s != nil:
-		clis := clients.Prefix(args.K8s.Namespace + "-k8s-")
-		if len(clis) == 0 {
-			clis = clients.Prefix("k8s-")
-			if len(clis) == 0 {
-				return "", errNoCollector
-			}
-		}
-		cli = clis[int(pid)%len(clis)]
-		err := args.sendTo(cli, pid, report)
+		cli, err := pickCollector(clients, pid,
+			args.K8s.Namespace+"-k8s-", "k8s-", legacyAssignment)
 		if err != nil {
 			return "", err
 		}
+		if err := args.sendTo(cli, pid, report); err != nil {
+			return "", err
+		}
 		return cli.ID(), nil
 	case args.File != nil:
 		for _, cli := range clients.All() {
 			err := args.sendTo(cli, pid, report)
 			if err != nil {
 				logging.Error("broadcast file logging config to %s: %v", cli.ID(), err)
+				recordSendError(pid, cli.ID(), args)
 				continue
 			}
 		}
 		return "", nil
+	case args.Docker != nil:
+		cli, err := pickCollector(clients, pid,
+			args.Docker.Host+"-docker-", "docker-", legacyAssignment)
+		if err != nil {
+			return "", err
+		}
+		if err := args.sendTo(cli, pid, report); err != nil {
+			return "", err
+		}
+		return cli.ID(), nil
 	default:
 		return "", errors.New("unsupported")
 	}
 }
+
+// pickCollector selects a collector for pid among clients registered under
+// prefix, falling back to fallbackPrefix (e.g. an unscoped "k8s-" group)
+// when the scoped prefix has no match. Rendezvous hashing is used unless
+// legacyAssignment restores the old pid%len(clis) modulo behavior, kept
+// only as a rollback switch since modulo reshuffles every project's
+// collector whenever one joins or leaves a prefix group.
+func pickCollector(clients *client.Clients, pid int64, prefix, fallbackPrefix string, legacyAssignment bool) (*client.Client, error) {
+	if legacyAssignment {
+		clis := clients.Prefix(prefix)
+		if len(clis) == 0 {
+			clis = clients.Prefix(fallbackPrefix)
+			if len(clis) == 0 {
+				return nil, errNoCollector
+			}
+		}
+		return clis[int(pid)%len(clis)], nil
+	}
+	key := strconv.FormatInt(pid, 10)
+	cli := clients.Pick(prefix, key)
+	if cli == nil {
+		cli = clients.Pick(fallbackPrefix, key)
+		if cli == nil {
+			return nil, errNoCollector
+		}
+	}
+	return cli, nil
+}
@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"net/http"
+	"server/code/client"
+	"sync"
+
+	"github.com/lwch/logging"
+)
+
+// Config holds the logging subsystem's process-wide settings, sourced from
+// the server's top-level configuration.
+type Config struct {
+	DataDir       string
+	LoggingReport string
+	// LegacyCollectorAssignment restores the old pid%len(clis) modulo
+	// collector assignment instead of rendezvous hashing. Exists purely
+	// as a rollback switch; leave unset in normal operation.
+	LegacyCollectorAssignment bool
+}
+
+// Handler serves the logging config HTTP endpoints and keeps track of the
+// collector currently assigned to each project.
+type Handler struct {
+	sync.Mutex
+	cfg  *Config
+	data map[int64]*context
+}
+
+// NewHandler builds the logging config handler, starts the config file
+// watcher that backs hot-reload, and mounts the package's debug endpoints
+// on mux. mux must be the mux the admin HTTP listener actually serves -
+// pass it in explicitly rather than assuming http.DefaultServeMux, since
+// nothing here can confirm the admin listener serves the default one.
+func NewHandler(cfg *Config, clients *client.Clients, mux *http.ServeMux) *Handler {
+	h := &Handler{
+		cfg:  cfg,
+		data: make(map[int64]*context),
+	}
+	rememberSpoolEnv(h, clients)
+	mux.Handle("/metrics", metricsHandler())
+	mux.HandleFunc("/logging/spool", spoolStatusHandler)
+	if err := h.watchConfigs(clients); err != nil {
+		logging.Error("watch logging configs: %v", err)
+	}
+	return h
+}
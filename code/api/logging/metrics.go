@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	dropReasonExclude    = "exclude"
+	dropReasonBufferFull = "buffer_full"
+	dropReasonSendError  = "send_error"
+)
+
+var (
+	// metricLinesTotal and metricBytesTotal are fed by the report endpoint
+	// that receives batched log lines from collectors, which lives outside
+	// this package — they're registered here so the series exists under
+	// this subsystem's name, but this package itself never increments them.
+	metricLinesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "smartagent_logging_lines_total",
+		Help: "total number of log lines received from collectors",
+	}, []string{"pid", "cid", "type"})
+
+	metricBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "smartagent_logging_bytes_total",
+		Help: "total number of log bytes received from collectors",
+	}, []string{"pid", "cid", "type"})
+
+	// metricDroppedTotal is split by reason: exclude/buffer_full are
+	// applied by the collector itself and surfaced through the report
+	// endpoint, while send_error is this package's own — incremented
+	// everywhere sendTo/reSend/spoolFailure give up on delivering a config.
+	metricDroppedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "smartagent_logging_dropped_total",
+		Help: "total number of log lines dropped, by reason",
+	}, []string{"pid", "cid", "type", "reason"})
+
+	metricSendErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "smartagent_logging_send_errors_total",
+		Help: "total number of errors sending logging configs/data to collectors",
+	}, []string{"pid", "cid", "type"})
+
+	metricBacklogBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "smartagent_logging_backlog_bytes",
+		Help: "bytes pending delivery in the on-disk spool",
+	}, []string{"pid"})
+)
+
+func configType(args *configArgs) string {
+	switch {
+	case args.K8s != nil:
+		return "k8s"
+	case args.File != nil:
+		return "file"
+	case args.Docker != nil:
+		return "docker"
+	default:
+		return "unknown"
+	}
+}
+
+// updateBacklogMetric refreshes the backlog gauge for a project from its
+// spool, called whenever the spool is written to or drained.
+func updateBacklogMetric(pid int64, s *spool) {
+	metricBacklogBytes.WithLabelValues(strconv.FormatInt(pid, 10)).Set(float64(s.pending()))
+}
+
+// recordSendError bumps both the send-error counter and the dropped-total
+// counter (reason=send_error) for a failed delivery to cid, so a config
+// that never makes it to a collector shows up under both series.
+func recordSendError(pid int64, cid string, args *configArgs) {
+	t := configType(args)
+	pidStr := strconv.FormatInt(pid, 10)
+	metricSendErrorsTotal.WithLabelValues(pidStr, cid, t).Inc()
+	metricDroppedTotal.WithLabelValues(pidStr, cid, t, dropReasonSendError).Inc()
+}
+
+// metricsHandler returns the Prometheus scrape handler for this package's
+// registry, for the caller to mount on its own admin mux.
+func metricsHandler() http.Handler {
+	return promhttp.Handler()
+}
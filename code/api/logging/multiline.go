@@ -0,0 +1,25 @@
+package logging
+
+// multilineConfig aggregates physical log lines that belong to a single
+// logical event (stack traces, Java/Python exceptions) into one record
+// before it is shipped, instead of forwarding one line at a time.
+type multilineConfig struct {
+	StartPattern string `json:"start_pattern"`
+	MaxLines     int    `json:"max_lines"`
+	MaxInterval  int    `json:"max_interval"` // milliseconds
+	Mode         string `json:"mode"`         // continue_past|continue_through
+}
+
+const (
+	multilineContinuePast    = "continue_past"
+	multilineContinueThrough = "continue_through"
+)
+
+// params returns the fields needed on the wire, defaulting to the
+// zero-value "disabled" tuple when multiline aggregation isn't configured.
+func (m *multilineConfig) params() (string, int, int, string) {
+	if m == nil {
+		return "", 0, 0, ""
+	}
+	return m.StartPattern, m.MaxLines, m.MaxInterval, m.Mode
+}
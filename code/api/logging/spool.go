@@ -0,0 +1,466 @@
+package logging
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"server/code/client"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jkstack/anet"
+	"github.com/lwch/logging"
+	"github.com/lwch/runtime"
+)
+
+const (
+	spoolSegmentMax = 64 * 1024 * 1024 // 64MiB
+	spoolRetryMin   = time.Second
+	spoolRetryMax   = time.Minute
+)
+
+// spoolCursor tracks the next unread record in a project's spool.
+type spoolCursor struct {
+	Segment int   `json:"segment"`
+	Offset  int64 `json:"offset"`
+}
+
+// spool is an append-only, per-project disk queue of messages that could
+// not be delivered to a collector. Records are length-prefixed and
+// terminated by a CRC32 footer so a torn write can be detected and
+// skipped on replay.
+type spool struct {
+	sync.Mutex
+	dir    string
+	seg    int
+	f      *os.File
+	size   int64
+	cursor spoolCursor
+}
+
+func spoolDir(dataDir string, pid int64) string {
+	return filepath.Join(dataDir, "logging", "spool", strconv.FormatInt(pid, 10))
+}
+
+func openSpool(dataDir string, pid int64) (*spool, error) {
+	dir := spoolDir(dataDir, pid)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &spool{dir: dir}
+	s.loadCursor()
+	s.seg = s.lastSegment()
+	return s, s.openSegment(s.seg)
+}
+
+func (s *spool) segmentPath(seg int) string {
+	return filepath.Join(s.dir, strconv.Itoa(seg)+".seg")
+}
+
+func (s *spool) cursorPath() string {
+	return filepath.Join(s.dir, "cursor.json")
+}
+
+func (s *spool) lastSegment() int {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0
+	}
+	max := 0
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) != ".seg" {
+			continue
+		}
+		var n int
+		_, err := fmt.Sscanf(e.Name(), "%d.seg", &n)
+		if err == nil && n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+func (s *spool) loadCursor() {
+	f, err := os.Open(s.cursorPath())
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	json.NewDecoder(f).Decode(&s.cursor)
+}
+
+func (s *spool) saveCursor() error {
+	f, err := os.Create(s.cursorPath())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(s.cursor)
+}
+
+func (s *spool) openSegment(seg int) error {
+	f, err := os.OpenFile(s.segmentPath(seg), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+	s.size = fi.Size()
+	return nil
+}
+
+// write appends msg to the current segment, rolling over to a new one
+// once the size cap is reached.
+func (s *spool) write(msg *anet.Msg) error {
+	s.Lock()
+	defer s.Unlock()
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if s.size+int64(len(payload))+8 > spoolSegmentMax {
+		s.f.Close()
+		s.seg++
+		if err := s.openSegment(s.seg); err != nil {
+			return err
+		}
+	}
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(payload)))
+	sum := crc32.ChecksumIEEE(payload)
+	var ftr [4]byte
+	binary.BigEndian.PutUint32(ftr[:], sum)
+	n, err := s.f.Write(hdr[:])
+	if err != nil {
+		return err
+	}
+	n2, err := s.f.Write(payload)
+	if err != nil {
+		return err
+	}
+	n3, err := s.f.Write(ftr[:])
+	if err != nil {
+		return err
+	}
+	s.size += int64(n + n2 + n3)
+	return nil
+}
+
+// replay streams pending records starting from the saved cursor, invoking
+// fn for each. On success it advances and persists the cursor; it stops at
+// the first delivery error so retries resume from that record.
+func (s *spool) replay(fn func(*anet.Msg) error) error {
+	s.Lock()
+	defer s.Unlock()
+	for seg := s.cursor.Segment; seg <= s.seg; seg++ {
+		f, err := os.Open(s.segmentPath(seg))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		off := int64(0)
+		if seg == s.cursor.Segment {
+			off = s.cursor.Offset
+		}
+		if _, err := f.Seek(off, io.SeekStart); err != nil {
+			f.Close()
+			return err
+		}
+		for {
+			var hdr [4]byte
+			_, err := io.ReadFull(f, hdr[:])
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return err
+			}
+			n := binary.BigEndian.Uint32(hdr[:])
+			payload := make([]byte, n)
+			if _, err := io.ReadFull(f, payload); err != nil {
+				f.Close()
+				return err
+			}
+			var ftr [4]byte
+			if _, err := io.ReadFull(f, ftr[:]); err != nil {
+				f.Close()
+				return err
+			}
+			if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(ftr[:]) {
+				logging.Error("spool %s: corrupt record in segment %d, skipping rest", s.dir, seg)
+				break
+			}
+			var msg anet.Msg
+			if err := json.Unmarshal(payload, &msg); err != nil {
+				f.Close()
+				return err
+			}
+			if err := fn(&msg); err != nil {
+				f.Close()
+				return err
+			}
+			pos, _ := f.Seek(0, io.SeekCurrent)
+			s.cursor.Segment = seg
+			s.cursor.Offset = pos
+			s.saveCursor()
+		}
+		f.Close()
+		if seg < s.seg {
+			os.Remove(s.segmentPath(seg))
+			s.cursor.Segment = seg + 1
+			s.cursor.Offset = 0
+			s.saveCursor()
+		}
+	}
+	return nil
+}
+
+// pending returns the total bytes not yet acknowledged across all segments.
+func (s *spool) pending() int64 {
+	s.Lock()
+	defer s.Unlock()
+	var total int64
+	for seg := s.cursor.Segment; seg <= s.seg; seg++ {
+		fi, err := os.Stat(s.segmentPath(seg))
+		if err != nil {
+			continue
+		}
+		size := fi.Size()
+		if seg == s.cursor.Segment {
+			size -= s.cursor.Offset
+		}
+		total += size
+	}
+	return total
+}
+
+// toMsg rebuilds the anet.Msg that sendTo would have sent, so a delivery
+// failure can be persisted to the spool and replayed later without having
+// to keep the live collector connection around.
+func (args *configArgs) toMsg(pid int64, report string) *anet.Msg {
+	mlPattern, mlMaxLines, mlMaxInterval, mlMode := args.Multiline.params()
+	switch {
+	case args.K8s != nil:
+		return &anet.Msg{
+			Type: anet.TypeLoggingConfigK8sReq,
+			LoggingConfigK8sReq: &anet.LoggingConfigK8sReq{
+				ID:                    pid,
+				Exclude:               args.Exclude,
+				Batch:                 args.Batch,
+				Buffer:                args.Buffer,
+				Interval:              args.Interval,
+				Report:                report,
+				Namespace:             args.K8s.Namespace,
+				Names:                 args.K8s.Names,
+				Dir:                   args.K8s.Dir,
+				Api:                   args.K8s.Api,
+				Token:                 args.K8s.Token,
+				MultilineStartPattern: mlPattern,
+				MultilineMaxLines:     mlMaxLines,
+				MultilineMaxInterval:  mlMaxInterval,
+				MultilineMode:         mlMode,
+			},
+		}
+	case args.File != nil:
+		return &anet.Msg{
+			Type: anet.TypeLoggingConfigFileReq,
+			LoggingConfigFileReq: &anet.LoggingConfigFileReq{
+				ID:                    pid,
+				Exclude:               args.Exclude,
+				Batch:                 args.Batch,
+				Buffer:                args.Buffer,
+				Interval:              args.Interval,
+				Report:                report,
+				Dir:                   args.File.Dir,
+				MultilineStartPattern: mlPattern,
+				MultilineMaxLines:     mlMaxLines,
+				MultilineMaxInterval:  mlMaxInterval,
+				MultilineMode:         mlMode,
+			},
+		}
+	case args.Docker != nil:
+		return &anet.Msg{
+			Type: anet.TypeLoggingConfigDockerReq,
+			LoggingConfigDockerReq: &anet.LoggingConfigDockerReq{
+				ID:                    pid,
+				Exclude:               args.Exclude,
+				Batch:                 args.Batch,
+				Buffer:                args.Buffer,
+				Interval:              args.Interval,
+				Report:                report,
+				Endpoint:              args.Docker.Endpoint,
+				TLSCert:               args.Docker.TLSCert,
+				TLSKey:                args.Docker.TLSKey,
+				TLSCA:                 args.Docker.TLSCA,
+				Name:                  args.Docker.Name,
+				Label:                 args.Docker.Label,
+				Since:                 args.Docker.Since,
+				Tail:                  args.Docker.Tail,
+				MultilineStartPattern: mlPattern,
+				MultilineMaxLines:     mlMaxLines,
+				MultilineMaxInterval:  mlMaxInterval,
+				MultilineMode:         mlMode,
+			},
+		}
+	default:
+		return nil
+	}
+}
+
+var (
+	spoolsMu sync.Mutex
+	spools   = map[int64]*spool{}
+)
+
+func getSpool(dataDir string, pid int64) *spool {
+	spoolsMu.Lock()
+	defer spoolsMu.Unlock()
+	if s, ok := spools[pid]; ok {
+		return s
+	}
+	s, err := openSpool(dataDir, pid)
+	runtime.Assert(err)
+	spools[pid] = s
+	return s
+}
+
+func spoolProjects() []int64 {
+	spoolsMu.Lock()
+	defer spoolsMu.Unlock()
+	ids := make([]int64, 0, len(spools))
+	for pid := range spools {
+		ids = append(ids, pid)
+	}
+	return ids
+}
+
+var (
+	spoolEnvMu      sync.Mutex
+	spoolEnvDir     string
+	spoolEnvClients *client.Clients
+	spoolEnvHandler *Handler
+	spoolRetryOnce  sync.Once
+)
+
+// rememberSpoolEnv records the handler/clients registry currently in use so
+// the retry goroutine, which outlives any single request, can reach them.
+func rememberSpoolEnv(h *Handler, clients *client.Clients) {
+	spoolEnvMu.Lock()
+	defer spoolEnvMu.Unlock()
+	spoolEnvDir = h.cfg.DataDir
+	spoolEnvClients = clients
+	spoolEnvHandler = h
+}
+
+func spoolDataDir() string {
+	spoolEnvMu.Lock()
+	defer spoolEnvMu.Unlock()
+	return spoolEnvDir
+}
+
+// startSpoolRetry launches a single background goroutine per process that
+// periodically retries delivery of spooled messages for every project that
+// currently has a reconnected, matching collector.
+func startSpoolRetry() {
+	spoolRetryOnce.Do(func() {
+		go spoolRetryLoop()
+	})
+}
+
+func spoolRetryLoop() {
+	backoff := spoolRetryMin
+	for {
+		time.Sleep(backoff)
+		spoolEnvMu.Lock()
+		h, clients := spoolEnvHandler, spoolEnvClients
+		spoolEnvMu.Unlock()
+		if h == nil || clients == nil {
+			continue
+		}
+		drained := true
+		for _, pid := range spoolProjects() {
+			h.Lock()
+			ctx, ok := h.data[pid]
+			h.Unlock()
+			if !ok {
+				continue
+			}
+			s := getSpool(h.cfg.DataDir, pid)
+			if s.pending() == 0 {
+				continue
+			}
+			var cli *client.Client
+			if ctx.CID != "" {
+				cli = clients.Get(ctx.CID)
+			}
+			if cli == nil {
+				// Either config() failed to deliver (no collector, or
+				// sendTo failed) or the assigned one dropped off; try to
+				// (re)assign one before giving up on this round.
+				cid, err := ctx.Args.send(clients, pid, h.cfg.LoggingReport, h.cfg.LegacyCollectorAssignment)
+				if err != nil {
+					drained = false
+					continue
+				}
+				ctx.CID = cid
+				h.Lock()
+				h.data[pid] = ctx
+				h.Unlock()
+				cli = clients.Get(cid)
+				if cli == nil {
+					drained = false
+					continue
+				}
+			}
+			err := s.replay(func(msg *anet.Msg) error {
+				_, err := cli.Send(msg)
+				return err
+			})
+			updateBacklogMetric(pid, s)
+			if err != nil {
+				logging.Error("retry spool of project %d: %v", pid, err)
+				drained = false
+			}
+		}
+		if drained {
+			backoff = spoolRetryMax
+		} else if backoff < spoolRetryMax {
+			backoff *= 2
+			if backoff > spoolRetryMax {
+				backoff = spoolRetryMax
+			}
+		}
+	}
+}
+
+// spoolStatusHandler reports the bytes pending delivery per project,
+// serving GET /logging/spool. Mounted by NewHandler on the caller's own
+// admin mux, since neither this nor /metrics needs the client registry
+// the rest of this package's endpoints are routed through.
+func spoolStatusHandler(w http.ResponseWriter, r *http.Request) {
+	dataDir := spoolDataDir()
+	pending := make(map[string]int64)
+	if dataDir != "" {
+		for _, pid := range spoolProjects() {
+			s := getSpool(dataDir, pid)
+			if n := s.pending(); n > 0 {
+				pending[strconv.FormatInt(pid, 10)] = n
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pending)
+}
@@ -0,0 +1,213 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"server/code/client"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/lwch/logging"
+)
+
+const watchDebounce = 200 * time.Millisecond
+
+// watchConfigs watches the logging config directory and hot-reloads any
+// config whose file is edited on disk, so operators can tune exclude,
+// batch, buffer, interval or collector selectors without an HTTP round
+// trip or a server restart.
+func (h *Handler) watchConfigs(clients *client.Clients) error {
+	dir := filepath.Join(h.cfg.DataDir, "logging")
+	os.MkdirAll(dir, 0755)
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := w.Add(dir); err != nil {
+		w.Close()
+		return err
+	}
+	go h.watchLoop(w, clients)
+	return nil
+}
+
+func (h *Handler) watchLoop(w *fsnotify.Watcher, clients *client.Clients) {
+	defer w.Close()
+	var mu sync.Mutex
+	timers := map[string]*time.Timer{}
+	debounce := func(path string, fn func()) {
+		mu.Lock()
+		defer mu.Unlock()
+		if t, ok := timers[path]; ok {
+			t.Stop()
+		}
+		timers[path] = time.AfterFunc(watchDebounce, fn)
+	}
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if filepath.Ext(ev.Name) != ".json" {
+				continue
+			}
+			switch {
+			case ev.Op&(fsnotify.Write|fsnotify.Create) != 0:
+				debounce(ev.Name, func() {
+					h.reloadConfig(ev.Name, clients)
+				})
+			case ev.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+				debounce(ev.Name, func() {
+					h.removeConfig(ev.Name, clients)
+				})
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			logging.Error("watch logging configs: %v", err)
+		}
+	}
+}
+
+func pidFromConfigPath(path string) (int64, error) {
+	name := strings.TrimSuffix(filepath.Base(path), ".json")
+	return strconv.ParseInt(name, 10, 64)
+}
+
+func validateConfigArgs(args *configArgs) error {
+	if len(args.Exclude) > 0 {
+		if _, err := regexp.Compile(args.Exclude); err != nil {
+			return fmt.Errorf("exclude: %v", err)
+		}
+	}
+	if args.Multiline != nil {
+		if _, err := regexp.Compile(args.Multiline.StartPattern); err != nil {
+			return fmt.Errorf("multiline.start_pattern: %v", err)
+		}
+		if args.Multiline.Mode != multilineContinuePast && args.Multiline.Mode != multilineContinueThrough {
+			return fmt.Errorf("multiline.mode: invalid value %q", args.Multiline.Mode)
+		}
+	}
+	switch {
+	case args.K8s != nil, args.File != nil, args.Docker != nil:
+		return nil
+	default:
+		return fmt.Errorf("type: missing k8s/file/docker config")
+	}
+}
+
+func (h *Handler) reloadConfig(path string, clients *client.Clients) {
+	pid, err := pidFromConfigPath(path)
+	if err != nil {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logging.Error("reload logging config of project %d: %v", pid, err)
+		}
+		return
+	}
+	var rt context
+	err = json.NewDecoder(f).Decode(&rt)
+	f.Close()
+	if err != nil {
+		logging.Error("reload logging config of project %d: %v", pid, err)
+		return
+	}
+	if err := validateConfigArgs(&rt.Args); err != nil {
+		logging.Error("reload logging config of project %d: %v", pid, err)
+		return
+	}
+
+	h.Lock()
+	old, hasOld := h.data[pid]
+	h.Unlock()
+
+	switch {
+	case rt.Args.File != nil:
+		// File/logtail configs are broadcast to every collector (see
+		// send()'s clients.All() loop), never bound to a single CID,
+		// so reload must re-broadcast instead of looking up old.CID.
+		if hasOld {
+			rt.Started = old.Started
+		}
+		for _, cli := range clients.All() {
+			rt.reSend(cli, h.cfg.LoggingReport)
+		}
+	case !hasOld || !sameCollectorSelector(&old.Args, &rt.Args):
+		cid, err := rt.Args.send(clients, rt.ID, h.cfg.LoggingReport, h.cfg.LegacyCollectorAssignment)
+		if err != nil {
+			logging.Error("reassign collector for project %d: %v", pid, err)
+			recordSendError(pid, "", &rt.Args)
+			return
+		}
+		rt.CID = cid
+	default:
+		rt.CID = old.CID
+		if cli := clients.Get(rt.CID); cli != nil {
+			rt.Started = old.Started
+			rt.reSend(cli, h.cfg.LoggingReport)
+		}
+	}
+
+	h.Lock()
+	h.data[pid] = &rt
+	h.Unlock()
+}
+
+// sameCollectorSelector reports whether old and cur still target the same
+// single collector, so reloadConfig knows whether it can reuse the
+// existing assignment instead of picking a new one. Only meaningful for
+// the single-collector (k8s/docker) kinds; File configs are broadcast and
+// never reach this check.
+func sameCollectorSelector(old, cur *configArgs) bool {
+	switch {
+	case old.K8s != nil && cur.K8s != nil:
+		return old.K8s.Namespace == cur.K8s.Namespace
+	case old.Docker != nil && cur.Docker != nil:
+		return old.Docker.Host == cur.Docker.Host
+	default:
+		return false
+	}
+}
+
+func (h *Handler) removeConfig(path string, clients *client.Clients) {
+	pid, err := pidFromConfigPath(path)
+	if err != nil {
+		return
+	}
+	h.Lock()
+	ctx, ok := h.data[pid]
+	delete(h.data, pid)
+	h.Unlock()
+	if !ok {
+		return
+	}
+	if ctx.Args.File != nil {
+		// Broadcast config was never bound to a single CID, so the
+		// stop has to go out to every collector too.
+		for _, cli := range clients.All() {
+			if _, err := cli.SendLoggingStop(pid); err != nil {
+				logging.Error("send logging stop of project %d to %s: %v", pid, cli.ID(), err)
+			}
+		}
+		return
+	}
+	cli := clients.Get(ctx.CID)
+	if cli == nil {
+		return
+	}
+	_, err = cli.SendLoggingStop(pid)
+	if err != nil {
+		logging.Error("send logging stop of project %d: %v", pid, err)
+	}
+}
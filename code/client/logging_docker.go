@@ -0,0 +1,34 @@
+package client
+
+import "github.com/jkstack/anet"
+
+// SendLoggingConfigDocker sends a docker logging configuration to the client,
+// mirroring SendLoggingConfigK8s/SendLoggingConfigFile.
+func (c *Client) SendLoggingConfigDocker(pid int64, exclude string,
+	batch, buffer, interval int, report string,
+	endpoint, tlsCert, tlsKey, tlsCA, name, label, since, tail string,
+	multilineStartPattern string, multilineMaxLines, multilineMaxInterval int, multilineMode string) (uint64, error) {
+	return c.send(&anet.Msg{
+		Type: anet.TypeLoggingConfigDockerReq,
+		LoggingConfigDockerReq: &anet.LoggingConfigDockerReq{
+			ID:                    pid,
+			Exclude:               exclude,
+			Batch:                 batch,
+			Buffer:                buffer,
+			Interval:              interval,
+			Report:                report,
+			Endpoint:              endpoint,
+			TLSCert:               tlsCert,
+			TLSKey:                tlsKey,
+			TLSCA:                 tlsCA,
+			Name:                  name,
+			Label:                 label,
+			Since:                 since,
+			Tail:                  tail,
+			MultilineStartPattern: multilineStartPattern,
+			MultilineMaxLines:     multilineMaxLines,
+			MultilineMaxInterval:  multilineMaxInterval,
+			MultilineMode:         multilineMode,
+		},
+	})
+}
@@ -0,0 +1,46 @@
+package client
+
+import "hash/fnv"
+
+// Pick selects one client among those registered under prefix using
+// rendezvous (highest random weight) hashing on key, so that removing or
+// adding a client only reshuffles the assignment for keys that hashed
+// highest on that client, instead of reshuffling everything the way a
+// plain modulo over the client count does. Returns nil if prefix matches
+// no client.
+func (c *Clients) Pick(prefix, key string) *Client {
+	clis := c.Prefix(prefix)
+	if len(clis) == 0 {
+		return nil
+	}
+	byID := make(map[string]*Client, len(clis))
+	ids := make([]string, 0, len(clis))
+	for _, cli := range clis {
+		id := cli.ID()
+		byID[id] = cli
+		ids = append(ids, id)
+	}
+	return byID[pickID(ids, key)]
+}
+
+// pickID returns the id with the highest rendezvous hash against key.
+func pickID(ids []string, key string) string {
+	var best string
+	var bestHash uint64
+	for i, id := range ids {
+		h := rendezvousHash(id, key)
+		if i == 0 || h > bestHash {
+			best = id
+			bestHash = h
+		}
+	}
+	return best
+}
+
+func rendezvousHash(id, key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	h.Write([]byte("|"))
+	h.Write([]byte(key))
+	return h.Sum64()
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPickIDStableUnderRemoval(t *testing.T) {
+	const n = 10
+	const projects = 1000
+
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("collector-%d", i)
+	}
+
+	before := make(map[int]string, projects)
+	for pid := 0; pid < projects; pid++ {
+		key := fmt.Sprintf("%d", pid)
+		before[pid] = pickID(ids, key)
+	}
+
+	removed := ids[len(ids)/2]
+	after := make([]string, 0, n-1)
+	for _, id := range ids {
+		if id != removed {
+			after = append(after, id)
+		}
+	}
+
+	changed := 0
+	for pid := 0; pid < projects; pid++ {
+		key := fmt.Sprintf("%d", pid)
+		if before[pid] == removed {
+			continue // was on the removed collector, must move, doesn't count against the bound
+		}
+		if pickID(after, key) != before[pid] {
+			changed++
+		}
+	}
+
+	maxExpected := projects / n
+	if changed > maxExpected {
+		t.Fatalf("removing 1 of %d collectors reshuffled %d projects, want <= %d",
+			n, changed, maxExpected)
+	}
+}